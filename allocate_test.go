@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mpreu/k8s-device-plugin-v4l2loopback/v4l2l"
+	api "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+func TestCreateContainerAllocateResponse(t *testing.T) {
+	plugin := &V4l2lDevicePlugin{
+		pool: PoolConfig{Permissions: "rw"},
+		deviceMap: map[string]v4l2l.Device{
+			"video0": {Name: "video0", Path: "/dev/video0", CardLabel: "camera-front"},
+			"video1": {Name: "video1", Path: "/dev/video1", CardLabel: "camera-rear"},
+		},
+	}
+
+	request := &api.ContainerAllocateRequest{
+		DevicesIDs: []string{"video0", "missing", "video1"},
+	}
+
+	response := createContainerAllocateResponse(plugin, request)
+
+	if len(response.Devices) != 2 {
+		t.Fatalf("got %d device specs, want 2 (the unknown ID should be skipped)", len(response.Devices))
+	}
+
+	for i, want := range []string{"/dev/video0", "/dev/video1"} {
+		if got := response.Devices[i].ContainerPath; got != want {
+			t.Errorf("Devices[%d].ContainerPath = %q, want %q (unknown ID must not leave a gap)", i, got, want)
+		}
+	}
+
+	if got, want := response.Envs[v4l2lDevicesEnv], "/dev/video0,/dev/video1"; got != want {
+		t.Errorf("%s = %q, want %q", v4l2lDevicesEnv, got, want)
+	}
+	if got, want := response.Envs[v4l2lLabelsEnv], "camera-front,camera-rear"; got != want {
+		t.Errorf("%s = %q, want %q", v4l2lLabelsEnv, got, want)
+	}
+}
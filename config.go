@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+
+	yaml "gopkg.in/yaml.v2"
+	"k8s.io/klog/v2"
+)
+
+var configPath = flag.String("config", "/etc/v4l2l-dp/config.yaml", "Path to the resource pool configuration file (YAML or JSON)")
+
+func init() {
+	// Make klog's flags (-v, -logtostderr, ...) part of the plugin's own
+	// flag parsing instead of requiring a separate flag set.
+	klog.InitFlags(nil)
+}
+
+// PoolConfig describes one advertised resource pool: a named group of
+// v4l2loopback devices selected by a card_label regex, along with the
+// container device permissions and replica count to advertise them
+// with.
+type PoolConfig struct {
+	ResourceName string `yaml:"resourceName"`
+	Selector     string `yaml:"selector"`
+	Permissions  string `yaml:"permissions"`
+	Replicas     int    `yaml:"replicas"`
+}
+
+// Config is the top-level resource pool configuration file format.
+type Config struct {
+	Pools []PoolConfig `yaml:"pools"`
+}
+
+// defaultConfig advertises every v4l2loopback device under the legacy
+// single resourceName, matching the plugin's behavior before pool
+// configuration existed.
+func defaultConfig() *Config {
+	return &Config{
+		Pools: []PoolConfig{
+			{ResourceName: resourceName, Selector: ".*", Permissions: "rw", Replicas: 1},
+		},
+	}
+}
+
+// loadConfig reads and parses the pool configuration file at path. JSON
+// is accepted too, since it is valid YAML.
+func loadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	for i := range cfg.Pools {
+		if cfg.Pools[i].Permissions == "" {
+			cfg.Pools[i].Permissions = "rw"
+		}
+		if cfg.Pools[i].Replicas == 0 {
+			cfg.Pools[i].Replicas = 1
+		}
+	}
+
+	return &cfg, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("could not write test config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigAppliesDefaults(t *testing.T) {
+	path := writeConfigFile(t, `
+pools:
+  - resourceName: mpreu.de/v4l2l-camera
+    selector: "^camera-"
+  - resourceName: mpreu.de/v4l2l-screen
+    selector: "^screen-"
+    permissions: r
+    replicas: 3
+`)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig returned error: %v", err)
+	}
+
+	if len(cfg.Pools) != 2 {
+		t.Fatalf("got %d pools, want 2", len(cfg.Pools))
+	}
+
+	camera := cfg.Pools[0]
+	if camera.Permissions != "rw" {
+		t.Errorf("camera pool Permissions = %q, want default %q", camera.Permissions, "rw")
+	}
+	if camera.Replicas != 1 {
+		t.Errorf("camera pool Replicas = %d, want default 1", camera.Replicas)
+	}
+
+	screen := cfg.Pools[1]
+	if screen.Permissions != "r" {
+		t.Errorf("screen pool Permissions = %q, want %q", screen.Permissions, "r")
+	}
+	if screen.Replicas != 3 {
+		t.Errorf("screen pool Replicas = %d, want 3", screen.Replicas)
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "does-not-exist.yaml")); err == nil {
+		t.Fatal("loadConfig with a missing file should return an error")
+	}
+}
+
+func TestLoadConfigAcceptsJSON(t *testing.T) {
+	path := writeConfigFile(t, `{"pools": [{"resourceName": "mpreu.de/v4l2l", "selector": ".*"}]}`)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig returned error: %v", err)
+	}
+	if len(cfg.Pools) != 1 || cfg.Pools[0].ResourceName != "mpreu.de/v4l2l" {
+		t.Fatalf("got pools %+v, want a single mpreu.de/v4l2l pool", cfg.Pools)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/mpreu/k8s-device-plugin-v4l2loopback/v4l2l"
+)
+
+// withFakeDevices points listDevices at a fixed device list for the
+// duration of the test, so scanDevices's selector-matching and
+// replica-expansion logic can be exercised without real v4l2loopback
+// devices.
+func withFakeDevices(t *testing.T, devices []v4l2l.Device) {
+	t.Helper()
+
+	orig := listDevices
+	listDevices = func() []v4l2l.Device { return devices }
+	t.Cleanup(func() { listDevices = orig })
+}
+
+func TestScanDevicesSelectsAndReplicates(t *testing.T) {
+	withFakeDevices(t, []v4l2l.Device{
+		{Name: "video0", Path: "/dev/video0", CardLabel: "camera-front"},
+		{Name: "video1", Path: "/dev/video1", CardLabel: "screen-capture"},
+	})
+
+	pool := PoolConfig{ResourceName: "mpreu.de/v4l2l-camera", Replicas: 2}
+	selector := regexp.MustCompile("^camera-")
+
+	devMap, devices := scanDevices(pool, selector)
+
+	if len(devices) != 2 {
+		t.Fatalf("got %d devices, want 2 (screen-capture should be filtered out)", len(devices))
+	}
+
+	var ids []string
+	for _, d := range devices {
+		ids = append(ids, d.ID)
+	}
+	sort.Strings(ids)
+
+	want := []string{"video0-0", "video0-1"}
+	for i, id := range want {
+		if ids[i] != id {
+			t.Errorf("ids[%d] = %q, want %q", i, ids[i], id)
+		}
+	}
+
+	if _, ok := devMap["video0-0"]; !ok {
+		t.Errorf("devMap missing replicated ID %q", "video0-0")
+	}
+}
+
+func TestScanDevicesNoReplicasKeepsBaselineID(t *testing.T) {
+	withFakeDevices(t, []v4l2l.Device{
+		{Name: "video0", Path: "/dev/video0", CardLabel: "camera-front"},
+	})
+
+	pool := PoolConfig{ResourceName: resourceName, Replicas: 1}
+	selector := regexp.MustCompile(".*")
+
+	devMap, devices := scanDevices(pool, selector)
+
+	if len(devices) != 1 || devices[0].ID != "video0" {
+		t.Fatalf("got devices %v, want a single device with ID %q", devices, "video0")
+	}
+	if _, ok := devMap["video0"]; !ok {
+		t.Errorf("devMap missing unreplicated ID %q", "video0")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+	api "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+var healthCheckInterval = flag.Duration("health-check-interval", 30*time.Second, "Interval between v4l2loopback device health checks")
+
+// v4l2loopbackDriverName is the driver string the v4l2loopback kernel
+// module reports for VIDIOC_QUERYCAP.
+const v4l2loopbackDriverName = "v4l2 loopback"
+
+// vidiocQueryCap is the VIDIOC_QUERYCAP ioctl request number.
+const vidiocQueryCap = 0x80685600
+
+// v4l2Capability mirrors the kernel's struct v4l2_capability as filled
+// in by the VIDIOC_QUERYCAP ioctl.
+type v4l2Capability struct {
+	Driver       [16]byte
+	Card         [32]byte
+	BusInfo      [32]byte
+	Version      uint32
+	Capabilities uint32
+	DeviceCaps   uint32
+	Reserved     [3]uint32
+}
+
+// healthCheck periodically probes every known device and pushes updated
+// health states through the plugin's update channel.
+func healthCheck(plugin *V4l2lDevicePlugin) {
+	ticker := time.NewTicker(*healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			plugin.refreshHealth()
+		case <-plugin.stopCh:
+			return
+		}
+	}
+}
+
+// refreshHealth probes each known device and, if any health state
+// changed, swaps in a freshly built device list and notifies
+// ListAndWatch. New *api.Device values are built rather than mutating
+// the ones already referenced by plugin.devices, since ListAndWatch
+// reads that slice - and marshals its devices - outside of plugin.mu.
+func (plugin *V4l2lDevicePlugin) refreshHealth() {
+	plugin.mu.RLock()
+	oldDevices := plugin.devices
+	deviceMap := plugin.deviceMap
+	plugin.mu.RUnlock()
+
+	changed := false
+	unhealthy := 0
+	devices := make([]*api.Device, len(oldDevices))
+
+	for i, device := range oldDevices {
+		d, ok := deviceMap[device.ID]
+		if !ok {
+			devices[i] = device
+			continue
+		}
+
+		health := api.Unhealthy
+		if probeDevice(d.Path) {
+			health = api.Healthy
+		}
+
+		if health != device.Health {
+			changed = true
+		}
+		if health == api.Unhealthy {
+			unhealthy++
+		}
+
+		devices[i] = &api.Device{ID: device.ID, Health: health}
+	}
+
+	devicesUnhealthy.WithLabelValues(plugin.resourceName).Set(float64(unhealthy))
+
+	if !changed {
+		return
+	}
+
+	plugin.mu.Lock()
+	plugin.devices = devices
+	plugin.mu.Unlock()
+
+	// Don't block forever if no ListAndWatch stream is currently reading
+	// updateCh (e.g. between a Kubelet disconnect and reconnect).
+	select {
+	case plugin.updateCh <- devices:
+	case <-plugin.stopCh:
+	}
+}
+
+// probeDevice opens the given device node and issues VIDIOC_QUERYCAP to
+// confirm it is still a responsive v4l2loopback device.
+func probeDevice(path string) bool {
+	fd, err := unix.Open(path, unix.O_RDWR|unix.O_NONBLOCK, 0)
+	if err != nil {
+		klog.V(4).Infof("Could not open %s for health check: %v", path, err)
+		return false
+	}
+	defer unix.Close(fd)
+
+	var cap v4l2Capability
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(vidiocQueryCap), uintptr(unsafe.Pointer(&cap))); errno != 0 {
+		klog.V(4).Infof("VIDIOC_QUERYCAP failed for %s: %v", path, errno)
+		return false
+	}
+
+	driver := strings.TrimRight(string(cap.Driver[:]), "\x00")
+	return driver == v4l2loopbackDriverName
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"flag"
+
+	"k8s.io/klog/v2"
+)
+
+func main() {
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		klog.Warningf("Could not load pool configuration from %s, falling back to the default pool: %v", *configPath, err)
+		cfg = defaultConfig()
+	}
+
+	if err := NewManager(cfg).Serve(); err != nil {
+		klog.Fatalf("Could not serve device plugin: %v", err)
+	}
+
+	select {}
+}
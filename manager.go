@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"regexp"
+
+	"k8s.io/klog/v2"
+	api "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+var debugAddr = flag.String("debug-addr", ":8080", "Address to serve the /healthz and /metrics debug endpoints on")
+
+// Scanner builds one V4l2lDevicePlugin per configured resource pool.
+type Scanner struct {
+	config *Config
+}
+
+// NewScanner constructs a Scanner for the given pool configuration.
+func NewScanner(config *Config) *Scanner {
+	return &Scanner{config: config}
+}
+
+// Scan compiles each pool's selector and constructs its device plugin.
+func (s *Scanner) Scan() ([]*V4l2lDevicePlugin, error) {
+	var plugins []*V4l2lDevicePlugin
+
+	for _, pool := range s.config.Pools {
+		selector, err := regexp.Compile(pool.Selector)
+		if err != nil {
+			return nil, err
+		}
+
+		plugins = append(plugins, NewV4l2lDevicePlugin(pool, selector))
+	}
+
+	return plugins, nil
+}
+
+// Manager owns one V4l2lDevicePlugin per configured resource pool and
+// drives their lifecycle together, so the rest of the program can treat
+// a multi-pool deployment the same as a single-pool one.
+type Manager struct {
+	scanner *Scanner
+	plugins []*V4l2lDevicePlugin
+}
+
+// NewManager constructs a Manager from the given pool configuration.
+func NewManager(config *Config) *Manager {
+	return &Manager{scanner: NewScanner(config)}
+}
+
+// Serve scans the configured pools and starts a device plugin for each.
+// If starting any pool fails, the pools already started are stopped.
+func (m *Manager) Serve() error {
+	plugins, err := m.scanner.Scan()
+	if err != nil {
+		return err
+	}
+	m.plugins = plugins
+
+	for _, plugin := range m.plugins {
+		if err := plugin.Serve(); err != nil {
+			klog.Errorf("Could not serve resource pool %s: %v", plugin.resourceName, err)
+			m.Stop()
+			return err
+		}
+	}
+
+	http.HandleFunc("/healthz", m.serveHealth)
+	go func() {
+		if err := http.ListenAndServe(*debugAddr, nil); err != nil {
+			klog.Errorf("Debug endpoint on %s stopped serving: %v", *debugAddr, err)
+		}
+	}()
+
+	return nil
+}
+
+// serveHealth exposes the last-known device list, across all resource
+// pools, for debugging.
+func (m *Manager) serveHealth(w http.ResponseWriter, r *http.Request) {
+	devices := make(map[string][]*api.Device, len(m.plugins))
+
+	for _, plugin := range m.plugins {
+		plugin.mu.RLock()
+		devices[plugin.resourceName] = plugin.devices
+		plugin.mu.RUnlock()
+	}
+
+	if err := json.NewEncoder(w).Encode(devices); err != nil {
+		klog.Errorf("Could not encode health response: %v", err)
+	}
+}
+
+// Stop stops every pool's device plugin.
+func (m *Manager) Stop() {
+	for _, plugin := range m.plugins {
+		if err := plugin.StopServer(); err != nil {
+			klog.Errorf("Could not stop resource pool %s: %v", plugin.resourceName, err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	allocateRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "allocate_requests_total",
+		Help: "Total number of Allocate requests handled, by resource pool.",
+	}, []string{"pool"})
+
+	allocateErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "allocate_errors_total",
+		Help: "Total number of unknown device IDs referenced by Allocate requests.",
+	})
+
+	devicesAvailable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "devices_available",
+		Help: "Number of devices currently advertised, by resource pool.",
+	}, []string{"pool"})
+
+	devicesUnhealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "devices_unhealthy",
+		Help: "Number of devices currently unhealthy, by resource pool.",
+	}, []string{"pool"})
+
+	registrationsTotal = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "registrations_total",
+		Help: "Number of times a resource pool has successfully registered with the Kubelet.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		allocateRequestsTotal,
+		allocateErrorsTotal,
+		devicesAvailable,
+		devicesUnhealthy,
+		registrationsTotal,
+	)
+	http.Handle("/metrics", promhttp.Handler())
+}
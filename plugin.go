@@ -2,53 +2,122 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"os"
 	"path"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/mpreu/k8s-device-plugin-v4l2loopback/v4l2l"
 	"google.golang.org/grpc"
+	"k8s.io/klog/v2"
+	registerapi "k8s.io/kubelet/pkg/apis/pluginregistration/v1"
 	api "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
 )
 
-const (
-	// pluginSocket describes the local path to the socket file on the system.
-	pluginSocket = api.DevicePluginPath + "v4l2l.sock"
-	resourceName = "mpreu.de/v4l2l"
-)
+// resourceName is the resource name advertised when no pool
+// configuration is given; see defaultConfig.
+const resourceName = "mpreu.de/v4l2l"
 
 // V4l2lDevicePlugin is the type which implements the Kubernetes
-// device plugin interface.
+// device plugin interface. Each instance serves a single resource pool.
 type V4l2lDevicePlugin struct {
 	resourceName string
+	pool         PoolConfig
+	selector     *regexp.Regexp
 	socketName   string
-	deviceMap    map[string]v4l2l.Device
-	devices      []*api.Device
+	watcherMode  bool
 	server       *grpc.Server
+
+	mu        sync.RWMutex
+	deviceMap map[string]v4l2l.Device
+	devices   []*api.Device
+
+	updateCh chan []*api.Device
+	stopCh   chan struct{}
 }
 
-// NewV4l2lDevicePlugin constructs a V4l2lDevicePlugin
-func NewV4l2lDevicePlugin() *V4l2lDevicePlugin {
+// NewV4l2lDevicePlugin constructs a V4l2lDevicePlugin serving the given
+// resource pool.
+func NewV4l2lDevicePlugin(pool PoolConfig, selector *regexp.Regexp) *V4l2lDevicePlugin {
 
-	devMap := make(map[string]v4l2l.Device)
-	var devices []*api.Device
+	devMap, devices := scanDevices(pool, selector)
+	watcherMode := detectPluginWatchMode()
 
-	for _, device := range v4l2l.GetDeviceList() {
-		id := device.Name
-		devMap[id] = device
-		devices = append(devices, &api.Device{
-			ID:     id,
-			Health: api.Healthy,
-		})
-	}
+	devicesAvailable.WithLabelValues(pool.ResourceName).Set(float64(len(devices)))
 
 	return &V4l2lDevicePlugin{
-		resourceName: resourceName,
-		socketName:   pluginSocket,
+		resourceName: pool.ResourceName,
+		pool:         pool,
+		selector:     selector,
+		socketName:   socketPath(pool.ResourceName, watcherMode),
+		watcherMode:  watcherMode,
 		deviceMap:    devMap,
 		devices:      devices,
+		updateCh:     make(chan []*api.Device),
+		stopCh:       make(chan struct{}),
+	}
+}
+
+// listDevices is a seam over v4l2l.GetDeviceList so scanDevices's
+// selector-matching and replica-expansion logic can be exercised with
+// fake devices in tests.
+var listDevices = v4l2l.GetDeviceList
+
+// scanDevices queries v4l2l for the currently available v4l2loopback
+// devices, keeps only those whose card_label matches the pool's
+// selector, and replicates each match pool.Replicas times so it can be
+// allocated to multiple containers.
+func scanDevices(pool PoolConfig, selector *regexp.Regexp) (map[string]v4l2l.Device, []*api.Device) {
+	devMap := make(map[string]v4l2l.Device)
+	var devices []*api.Device
+
+	for _, device := range listDevices() {
+		if !selector.MatchString(device.CardLabel) {
+			continue
+		}
+
+		for r := 0; r < pool.Replicas; r++ {
+			// Only suffix the ID when actually replicating a device; an
+			// unreplicated pool keeps the baseline's bare device.Name so
+			// upgrading from the old plugin doesn't invalidate the
+			// Kubelet's device-manager checkpoint.
+			id := device.Name
+			if pool.Replicas > 1 {
+				id = fmt.Sprintf("%s-%d", device.Name, r)
+			}
+			devMap[id] = device
+			devices = append(devices, &api.Device{
+				ID:     id,
+				Health: api.Healthy,
+			})
+		}
+	}
+
+	return devMap, devices
+}
+
+// refreshDevices rescans the available v4l2loopback devices, updates the
+// plugin's device map under lock, and pushes the refreshed list onto
+// updateCh so ListAndWatch can notify the Kubelet.
+func (plugin *V4l2lDevicePlugin) refreshDevices() {
+	devMap, devices := scanDevices(plugin.pool, plugin.selector)
+
+	plugin.mu.Lock()
+	plugin.deviceMap = devMap
+	plugin.devices = devices
+	plugin.mu.Unlock()
+
+	devicesAvailable.WithLabelValues(plugin.resourceName).Set(float64(len(devices)))
+
+	// Don't block forever if no ListAndWatch stream is currently reading
+	// updateCh (e.g. between a Kubelet disconnect and reconnect).
+	select {
+	case plugin.updateCh <- devices:
+	case <-plugin.stopCh:
 	}
 }
 
@@ -63,11 +132,11 @@ func (plugin *V4l2lDevicePlugin) GetDevicePluginOptions(context.Context, *api.Em
 // Register registers the device plugin with the given resource name with the Kubelet.
 func (plugin *V4l2lDevicePlugin) Register(kubeletEndpoint string, resourceName string) error {
 
-	log.Debugln("Entering register function")
+	klog.V(4).Infoln("Entering register function")
 
 	conn, err := checkServerConnection(kubeletEndpoint)
 	if err != nil {
-		log.Errorf("Cannot establish connection to Kubelet endpoint: %v", err)
+		klog.Errorf("Cannot establish connection to Kubelet endpoint: %v", err)
 		return err
 	}
 	defer conn.Close()
@@ -76,17 +145,18 @@ func (plugin *V4l2lDevicePlugin) Register(kubeletEndpoint string, resourceName s
 
 	request := &api.RegisterRequest{
 		Version:      api.Version,
-		Endpoint:     path.Base(pluginSocket),
+		Endpoint:     path.Base(plugin.socketName),
 		ResourceName: plugin.resourceName,
 	}
 
-	log.Debugf("RegisterRequest: %v", request)
+	klog.V(4).Infof("RegisterRequest: %v", request)
 
 	_, err = client.Register(context.Background(), request)
 	if err != nil {
-		log.Errorf("Sending plugin register request failed: %v", err)
+		klog.Errorf("Sending plugin register request failed: %v", err)
 		return err
 	}
+	registrationsTotal.Inc()
 
 	return nil
 
@@ -95,19 +165,29 @@ func (plugin *V4l2lDevicePlugin) Register(kubeletEndpoint string, resourceName s
 // ListAndWatch communicates changes of device states and returns a
 // new device list. Implementation of the 'DevicePluginServer' interface.
 func (plugin *V4l2lDevicePlugin) ListAndWatch(e *api.Empty, s api.DevicePlugin_ListAndWatchServer) error {
-	log.Debugf("ListAndWatch devices: %v", plugin.devices)
-	response := api.ListAndWatchResponse{
-		Devices: plugin.devices,
-	}
-	err := s.Send(&response)
+	plugin.mu.RLock()
+	devices := plugin.devices
+	plugin.mu.RUnlock()
 
-	if err != nil {
-		log.Errorf("Error when sending ListAndWatch response: %v", err)
+	klog.V(4).Infof("ListAndWatch devices: %v", devices)
+	if err := s.Send(&api.ListAndWatchResponse{Devices: devices}); err != nil {
+		klog.Errorf("Error when sending ListAndWatch response: %v", err)
 		return err
 	}
 
 	for {
-		select {}
+		select {
+		case devices := <-plugin.updateCh:
+			klog.V(4).Infof("ListAndWatch devices updated: %v", devices)
+			if err := s.Send(&api.ListAndWatchResponse{Devices: devices}); err != nil {
+				klog.Errorf("Error when sending ListAndWatch response: %v", err)
+				return err
+			}
+		case <-plugin.stopCh:
+			return nil
+		case <-s.Context().Done():
+			return s.Context().Err()
+		}
 	}
 }
 
@@ -115,24 +195,20 @@ func (plugin *V4l2lDevicePlugin) ListAndWatch(e *api.Empty, s api.DevicePlugin_L
 // container creation process. Implementation of the 'DevicePluginServer' interface.
 func (plugin *V4l2lDevicePlugin) Allocate(ctx context.Context, request *api.AllocateRequest) (*api.AllocateResponse, error) {
 
-	log.Debugf("Allocate request: %v", request.GetContainerRequests())
+	klog.V(4).Infof("Allocate request: %v", request.GetContainerRequests())
+	allocateRequestsTotal.WithLabelValues(plugin.resourceName).Inc()
 
 	responses := make([]*api.ContainerAllocateResponse, len(request.GetContainerRequests()))
 
 	for i, ctnRequest := range request.GetContainerRequests() {
-		specs := createDeviceSpecs(plugin, ctnRequest)
-
-		r := &api.ContainerAllocateResponse{
-			Devices: specs,
-		}
-		responses[i] = r
+		responses[i] = createContainerAllocateResponse(plugin, ctnRequest)
 	}
 
 	response := api.AllocateResponse{
 		ContainerResponses: responses,
 	}
 
-	log.Debugf("Allocate response: %v", response)
+	klog.V(4).Infof("Allocate response: %v", response)
 
 	return &response, nil
 }
@@ -147,7 +223,7 @@ func (plugin *V4l2lDevicePlugin) PreStartContainer(context.Context, *api.PreStar
 func (plugin *V4l2lDevicePlugin) StartServer() error {
 	plugin.server = grpc.NewServer([]grpc.ServerOption{}...)
 
-	listener, err := net.Listen("unix", pluginSocket)
+	listener, err := net.Listen("unix", plugin.socketName)
 
 	if err != nil {
 		return err
@@ -155,10 +231,14 @@ func (plugin *V4l2lDevicePlugin) StartServer() error {
 
 	api.RegisterDevicePluginServer(plugin.server, plugin)
 
+	if plugin.watcherMode {
+		registerapi.RegisterRegistrationServer(plugin.server, plugin)
+	}
+
 	go plugin.server.Serve(listener)
 
 	// Be sure the connection is established
-	conn, err := checkServerConnection(pluginSocket)
+	conn, err := checkServerConnection(plugin.socketName)
 	if err != nil {
 		return err
 	}
@@ -173,69 +253,109 @@ func (plugin *V4l2lDevicePlugin) StopServer() error {
 		return nil
 	}
 
+	close(plugin.stopCh)
+
 	plugin.server.Stop()
 	plugin.server = nil
 
-	return cleanupSocket()
+	return cleanupSocket(plugin.socketName)
 }
 
 // Serve starts the gRPC server and registers the device plugin to the Kubelet.
 func (plugin *V4l2lDevicePlugin) Serve() error {
 	err := plugin.StartServer()
 	if err != nil {
-		log.Errorf("Could not start device plugin gRPC server: %v", err)
+		klog.Errorf("Could not start device plugin gRPC server: %v", err)
 		return err
 	}
 
-	log.Debugln("Start registering plugin to Kubelet")
+	if plugin.watcherMode {
+		// The Kubelet plugin-watcher discovers us by filesystem watch on
+		// pluginsRegistryPath and calls GetInfo/NotifyRegistrationStatus
+		// on our gRPC server; we don't push a Register request ourselves.
+		klog.V(4).Infof("Hosting plugin in Kubelet plugin-watcher registry at %s", plugin.socketName)
+	} else {
+		klog.V(4).Infoln("Start registering plugin to Kubelet")
+
+		err = plugin.Register(api.KubeletSocket, plugin.resourceName)
+		if err != nil {
+			klog.Errorf("Could not register device plugin to Kubelet: %s", err)
+			plugin.StopServer()
+			return err
+		}
 
-	err = plugin.Register(api.KubeletSocket, plugin.resourceName)
-	if err != nil {
-		log.Errorf("Could not register device plugin to Kubelet: %s", err)
-		plugin.StopServer()
-		return err
+		klog.V(4).Infoln("Registered device plugin to Kubelet")
 	}
 
-	log.Debugln("Registered device plugin to Kubelet")
+	go watchDevices(plugin)
+	go healthCheck(plugin)
 
 	return nil
 }
 
 // CleanupSocket deletes the socket for the device plugin
-func cleanupSocket() error {
-	if err := os.Remove(pluginSocket); err != nil && !os.IsNotExist(err) {
+func cleanupSocket(socketName string) error {
+	if err := os.Remove(socketName); err != nil && !os.IsNotExist(err) {
 		return err
 	}
 
 	return nil
 }
 
-// createDeviceSpec returns a kubernetes device spec for the
-// device plugin api based on a V4l2l device.
-func createDeviceSpec(d *v4l2l.Device) *api.DeviceSpec {
-	return &api.DeviceSpec{
-		ContainerPath: d.Path,
-		HostPath:      d.Path,
-		Permissions:   "rw",
-	}
-}
+// v4l2lDevicesEnv and v4l2lLabelsEnv are the env vars injected into an
+// allocated container so it can address its devices by a stable,
+// in-container path instead of the host's /dev/videoN numbering.
+const (
+	v4l2lDevicesEnv = "V4L2LOOPBACK_DEVICES"
+	v4l2lLabelsEnv  = "V4L2LOOPBACK_LABELS"
+)
 
-// createDeviceSpecs returns a list of kubernetes device specs
-// for the device plugin api. Based on a allocate request of a
-// kubelet the corresponding V4l2l devices are selected.
-func createDeviceSpecs(plugin *V4l2lDevicePlugin, request *api.ContainerAllocateRequest) []*api.DeviceSpec {
+// createContainerAllocateResponse builds the device specs and env vars
+// for one container's allocate request. Allocated devices are remapped
+// to stable in-container paths (/dev/video0, /dev/video1, ...) rather
+// than their host path, so two containers on the same node don't need
+// to agree on host device numbering.
+func createContainerAllocateResponse(plugin *V4l2lDevicePlugin, request *api.ContainerAllocateRequest) *api.ContainerAllocateResponse {
 	deviceIDs := request.GetDevicesIDs()
+
+	plugin.mu.RLock()
+	defer plugin.mu.RUnlock()
+
 	var specs []*api.DeviceSpec
+	var containerPaths []string
+	var labels []string
 
 	for _, deviceID := range deviceIDs {
-		log.Debugf("Process 'Allocate' for deviceID: %s", deviceID)
+		klog.V(4).Infof("Process 'Allocate' for deviceID: %s", deviceID)
 
-		currentDevice := plugin.deviceMap[deviceID]
-		ds := createDeviceSpec(&currentDevice)
-		specs = append(specs, ds)
+		currentDevice, ok := plugin.deviceMap[deviceID]
+		if !ok {
+			klog.Errorf("Allocate request for unknown deviceID: %s", deviceID)
+			allocateErrorsTotal.Inc()
+			continue
+		}
+
+		// Use the count of devices resolved so far, not the loop index,
+		// so a missing deviceID doesn't leave a gap in the numbering.
+		containerPath := fmt.Sprintf("/dev/video%d", len(specs))
+
+		specs = append(specs, &api.DeviceSpec{
+			ContainerPath: containerPath,
+			HostPath:      currentDevice.Path,
+			Permissions:   plugin.pool.Permissions,
+		})
+
+		containerPaths = append(containerPaths, containerPath)
+		labels = append(labels, currentDevice.CardLabel)
+	}
 
+	return &api.ContainerAllocateResponse{
+		Devices: specs,
+		Envs: map[string]string{
+			v4l2lDevicesEnv: strings.Join(containerPaths, ","),
+			v4l2lLabelsEnv:  strings.Join(labels, ","),
+		},
 	}
-	return specs
 }
 
 // checkServerConnection tests the gRPC server of the device plugin.
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+
+	"k8s.io/klog/v2"
+	registerapi "k8s.io/kubelet/pkg/apis/pluginregistration/v1"
+	api "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// pluginsRegistryPath is where the Kubelet plugin-watcher looks for
+// plugin sockets. If this directory exists we host our socket there and
+// let the Kubelet discover us, instead of pushing a Register RPC.
+const pluginsRegistryPath = "/var/lib/kubelet/plugins_registry"
+
+// detectPluginWatchMode reports whether the Kubelet on this node
+// supports the plugin-watcher registration model.
+func detectPluginWatchMode() bool {
+	_, err := os.Stat(pluginsRegistryPath)
+	return err == nil
+}
+
+// socketPath returns the socket path a pool's plugin should listen on,
+// depending on whether plugin-watcher mode was detected.
+func socketPath(poolResourceName string, watcherMode bool) string {
+	endpoint := sanitizeSocketName(poolResourceName) + ".sock"
+
+	if watcherMode {
+		return path.Join(pluginsRegistryPath, endpoint)
+	}
+	return api.DevicePluginPath + endpoint
+}
+
+// sanitizeSocketName turns a resource name such as "mpreu.de/v4l2l-camera"
+// into a filesystem-safe socket file name.
+func sanitizeSocketName(resourceName string) string {
+	replacer := strings.NewReplacer("/", "_", ".", "_")
+	return replacer.Replace(resourceName)
+}
+
+// GetInfo is called by the Kubelet plugin-watcher once it discovers our
+// socket. Implementation of the 'RegistrationServer' interface.
+func (plugin *V4l2lDevicePlugin) GetInfo(ctx context.Context, req *registerapi.InfoRequest) (*registerapi.PluginInfo, error) {
+	return &registerapi.PluginInfo{
+		Type:              registerapi.DevicePlugin,
+		Name:              plugin.resourceName,
+		Endpoint:          plugin.socketName,
+		SupportedVersions: []string{api.Version},
+	}, nil
+}
+
+// NotifyRegistrationStatus is called by the Kubelet plugin-watcher after
+// it has processed our GetInfo response. Implementation of the
+// 'RegistrationServer' interface.
+func (plugin *V4l2lDevicePlugin) NotifyRegistrationStatus(ctx context.Context, status *registerapi.RegistrationStatus) (*registerapi.RegistrationStatusResponse, error) {
+	if !status.PluginRegistered {
+		klog.Errorf("Kubelet plugin-watcher registration failed: %s", status.Error)
+	} else {
+		klog.V(4).Infoln("Kubelet plugin-watcher registration succeeded")
+		registrationsTotal.Inc()
+	}
+
+	return &registerapi.RegistrationStatusResponse{}, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/klog/v2"
+)
+
+// devWatchPaths are the filesystem locations watched for v4l2loopback
+// device nodes being added or removed at runtime.
+var devWatchPaths = []string{"/dev", "/sys/class/video4linux"}
+
+// watchDebounce coalesces bursts of fsnotify events (e.g. udev creating
+// several video* nodes for one modprobe) into a single rescan.
+const watchDebounce = 250 * time.Millisecond
+
+// watchDevices watches devWatchPaths for video* nodes appearing or
+// disappearing and triggers plugin.refreshDevices on change, which in
+// turn notifies ListAndWatch. It runs until plugin.stopCh is closed.
+func watchDevices(plugin *V4l2lDevicePlugin) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		klog.Errorf("Could not create device watcher: %v", err)
+		return
+	}
+	defer watcher.Close()
+
+	for _, p := range devWatchPaths {
+		if err := watcher.Add(p); err != nil {
+			klog.Warningf("Could not watch %s for device changes: %v", p, err)
+		}
+	}
+
+	var debounce *time.Timer
+	rescan := make(chan struct{}, 1)
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if !isVideoNode(event.Name) {
+				continue
+			}
+			klog.V(4).Infof("Device event: %v", event)
+
+			if debounce == nil {
+				debounce = time.AfterFunc(watchDebounce, func() {
+					select {
+					case rescan <- struct{}{}:
+					default:
+					}
+				})
+			} else {
+				debounce.Reset(watchDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			klog.Errorf("Device watcher error: %v", err)
+		case <-rescan:
+			plugin.refreshDevices()
+		case <-plugin.stopCh:
+			return
+		}
+	}
+}
+
+// isVideoNode reports whether path refers to a video4linux device node,
+// e.g. /dev/video0 or /sys/class/video4linux/video0.
+func isVideoNode(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), "video")
+}